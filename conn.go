@@ -0,0 +1,158 @@
+package peerstream
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/whyrusleeping/spdystream"
+)
+
+// GroupID identifies a set of Conns a caller has tagged together, e.g.
+// every Conn to a given peer. It is opaque to peerstream: any
+// comparable value works as a map key.
+type GroupID interface{}
+
+// ErrInvalidConnSelected signals that a connection returned from a
+// SelectConn function is invalid: either it wasn't part of the
+// candidate slice the function was given, or it was nil.
+var ErrInvalidConnSelected = errors.New("invalid selected connection")
+
+// ErrNoConnections signals that a Swarm has no connections to select
+// from.
+var ErrNoConnections = errors.New("no connections")
+
+// Conn is a Swarm-associated connection. It wraps a net.Conn that has
+// completed SPDY session setup and is ready to open and accept Streams.
+type Conn interface {
+	// Swarm returns the Swarm that owns this Conn.
+	Swarm() *Swarm
+	// NetConn returns the underlying net.Conn.
+	NetConn() net.Conn
+
+	// Groups returns the GroupIDs this Conn currently belongs to.
+	Groups() []GroupID
+	// InGroup reports whether this Conn belongs to g.
+	InGroup(g GroupID) bool
+	// AddGroup assigns g to this Conn.
+	AddGroup(g GroupID)
+
+	// Close tears down the SPDY session and the underlying net.Conn,
+	// resetting any Streams still open on it.
+	Close() error
+}
+
+// conn is the concrete implementation of Conn.
+type conn struct {
+	netConn net.Conn
+	ssConn  *spdystream.Connection
+	swarm   *Swarm
+
+	stat    *Stat
+	latency *streamLatencyEWMA
+
+	groupsLk sync.RWMutex
+	groups   map[GroupID]struct{}
+
+	closeLk sync.Mutex
+	closed  bool
+}
+
+// newConn wraps netConn in a *conn associated with s. The returned conn
+// has no ssConn yet; addConn sets it once the SPDY session is up.
+func newConn(netConn net.Conn, s *Swarm) *conn {
+	return &conn{
+		netConn: netConn,
+		swarm:   s,
+		groups:  make(map[GroupID]struct{}),
+	}
+}
+
+func (c *conn) Swarm() *Swarm     { return c.swarm }
+func (c *conn) NetConn() net.Conn { return c.netConn }
+
+func (c *conn) Groups() []GroupID {
+	c.groupsLk.RLock()
+	defer c.groupsLk.RUnlock()
+	out := make([]GroupID, 0, len(c.groups))
+	for g := range c.groups {
+		out = append(out, g)
+	}
+	return out
+}
+
+func (c *conn) InGroup(g GroupID) bool {
+	c.groupsLk.RLock()
+	defer c.groupsLk.RUnlock()
+	_, ok := c.groups[g]
+	return ok
+}
+
+// AddGroup assigns g to c, both locally and in the swarm-wide group
+// index that connsForGroup consults.
+func (c *conn) AddGroup(g GroupID) {
+	c.groupsLk.Lock()
+	c.groups[g] = struct{}{}
+	c.groupsLk.Unlock()
+
+	c.swarm.connGrps.add(g, c)
+}
+
+// Close tears down the SPDY session (which resets any open Streams)
+// and the underlying net.Conn, then removes c from its Swarm. It is
+// idempotent.
+func (c *conn) Close() error {
+	c.closeLk.Lock()
+	defer c.closeLk.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	var err error
+	if c.ssConn != nil {
+		err = c.ssConn.Close()
+	} else {
+		err = c.netConn.Close()
+	}
+	c.swarm.removeConn(c)
+	return err
+}
+
+// ConnInConns reports whether c belongs to conns.
+func ConnInConns(c Conn, conns []Conn) bool {
+	for _, oc := range conns {
+		if oc == c {
+			return true
+		}
+	}
+	return false
+}
+
+// addActiveConn registers c as one of the swarm's live connections.
+func (s *Swarm) addActiveConn(c *conn) {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	s.conns[c] = struct{}{}
+}
+
+// handleNewStream returns the spdystream.StreamHandler that AddConn's
+// SPDY session serves incoming streams to: every remotely-initiated
+// Stream on c is wrapped, tracked, and handed to the swarm's
+// StreamHandler.
+func (s *Swarm) handleNewStream(c *conn) spdystream.StreamHandler {
+	return func(ssStream *spdystream.Stream) {
+		stream := newStream(c, ssStream)
+
+		s.streamLock.Lock()
+		s.streams[stream] = struct{}{}
+		s.streamLock.Unlock()
+
+		c.stat.addStream()
+		s.notifyOpenedStream(stream)
+
+		if sh := s.StreamHandler(); sh != nil {
+			sh(stream)
+		}
+	}
+}
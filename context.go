@@ -0,0 +1,72 @@
+package peerstream
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrStreamTimeout is returned by the NewStream family when opening a
+// stream does not complete before the context's deadline (set via
+// WithStreamTimeout or an ancestor context) elapses.
+var ErrStreamTimeout = errors.New("peerstream: stream open timed out")
+
+// ErrStreamCanceled is returned by the NewStream family when the
+// passed context is canceled while a stream open is in flight.
+var ErrStreamCanceled = errors.New("peerstream: stream open canceled")
+
+// ErrNoDial is returned by NewStreamWithNetConnContext when ctx carries
+// WithNoDial, since adding the given net.Conn to the swarm is this
+// library's only connect/dial-like path.
+var ErrNoDial = errors.New("peerstream: NoDial set in context, refusing to add a new connection")
+
+type contextKey int
+
+const (
+	streamTimeoutKey contextKey = iota
+	connSelectorKey
+	noDialKey
+)
+
+// WithNoDial returns a copy of ctx marking that the call must not
+// establish a new connection to satisfy it, analogous to the external
+// libp2p swarm's NoDial. peerstream itself never dials; the only
+// connect-like path is NewStreamWithNetConnContext, which calls AddConn
+// on the caller's behalf, and is the path this gates. Calls that only
+// ever pick among already-added Conns (NewStreamSelectConnContext,
+// NewStreamWithGroupContext) are unaffected, since they never dial to
+// begin with.
+func WithNoDial(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noDialKey, true)
+}
+
+func noDialFromContext(ctx context.Context) bool {
+	noDial, _ := ctx.Value(noDialKey).(bool)
+	return noDial
+}
+
+// WithStreamTimeout returns a copy of ctx carrying a per-call timeout
+// that setupStream applies to the underlying ssConn.CreateStream, so a
+// stalled remote cannot block a NewStream call forever. It composes
+// with any deadline already on ctx; whichever fires first wins.
+func WithStreamTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, streamTimeoutKey, d)
+}
+
+func streamTimeoutFromContext(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Value(streamTimeoutKey).(time.Duration)
+	return d, ok
+}
+
+// WithConnSelector returns a copy of ctx carrying a SelectConn that
+// overrides the swarm's default selector for this call only. It is
+// consulted by NewStreamSelectConnContext and NewStreamWithGroupContext
+// in place of swarm.SelectConn().
+func WithConnSelector(ctx context.Context, sc SelectConn) context.Context {
+	return context.WithValue(ctx, connSelectorKey, sc)
+}
+
+func connSelectorFromContext(ctx context.Context) SelectConn {
+	sc, _ := ctx.Value(connSelectorKey).(SelectConn)
+	return sc
+}
@@ -0,0 +1,87 @@
+package peerstream
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/whyrusleeping/spdystream"
+)
+
+func TestWithStreamTimeoutRoundTrip(t *testing.T) {
+	ctx := WithStreamTimeout(context.Background(), 5*time.Second)
+	d, ok := streamTimeoutFromContext(ctx)
+	if !ok || d != 5*time.Second {
+		t.Fatalf("streamTimeoutFromContext = (%v, %v), want (5s, true)", d, ok)
+	}
+
+	if _, ok := streamTimeoutFromContext(context.Background()); ok {
+		t.Fatal("expected no timeout on a plain context")
+	}
+}
+
+func TestWithNoDialRoundTrip(t *testing.T) {
+	ctx := WithNoDial(context.Background())
+	if !noDialFromContext(ctx) {
+		t.Fatal("expected WithNoDial to mark the context")
+	}
+	if noDialFromContext(context.Background()) {
+		t.Fatal("expected a plain context to not carry NoDial")
+	}
+}
+
+func TestWithConnSelectorRoundTrip(t *testing.T) {
+	sc := SelectConn(SelectRandom)
+	ctx := WithConnSelector(context.Background(), sc)
+	if got := connSelectorFromContext(ctx); got == nil {
+		t.Fatal("expected a SelectConn to round-trip through the context")
+	}
+	if got := connSelectorFromContext(context.Background()); got != nil {
+		t.Fatal("expected no SelectConn on a plain context")
+	}
+}
+
+// TestSetupStreamCancelReturnsPromptly checks that a canceled context
+// unblocks setupStream instead of waiting on ssConn.CreateStream. The
+// server end of a net.Pipe is never read here, so CreateStream's frame
+// write on the client end genuinely blocks until the test's deferred
+// serverNetConn.Close closes the pipe - proving the ctx.Done() path,
+// not a lucky race, is what unblocks the call.
+func TestSetupStreamCancelReturnsPromptly(t *testing.T) {
+	clientNetConn, serverNetConn := net.Pipe()
+
+	ssConn, err := spdystream.NewConnection(clientNetConn, false)
+	if err != nil {
+		t.Fatalf("spdystream.NewConnection: %v", err)
+	}
+	// defers run LIFO: serverNetConn must close first, to unblock any
+	// frame write still in flight on the client side (including
+	// ssConn.Close's own GoAway) instead of deadlocking against the
+	// unread net.Pipe. So it's deferred last.
+	defer ssConn.Close()
+	defer serverNetConn.Close()
+
+	s := NewSwarm()
+	c := newConn(clientNetConn, s)
+	c.ssConn = ssConn
+	c.stat = newStat(DirOutbound)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.setupStream(ctx, c)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != ErrStreamCanceled {
+			t.Fatalf("setupStream error = %v, want ErrStreamCanceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("setupStream did not return after the context was canceled")
+	}
+}
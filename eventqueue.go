@@ -0,0 +1,57 @@
+package peerstream
+
+import "sync"
+
+// eventQueue is an unbounded FIFO queue of notifyEvents, used instead
+// of a fixed-size channel so a slow notifiee never has events dropped
+// out from under it - only delayed.
+type eventQueue struct {
+	mu     sync.Mutex
+	items  []notifyEvent
+	notify chan struct{}
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{notify: make(chan struct{}, 1)}
+}
+
+// push appends evt to the queue and wakes a pending pop, if any.
+func (q *eventQueue) push(evt notifyEvent) {
+	q.mu.Lock()
+	q.items = append(q.items, evt)
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes and returns the oldest queued event, blocking until one
+// is available or stop is closed, in which case ok is false. Once stop
+// is closed, events still in the queue are not returned.
+func (q *eventQueue) pop(stop <-chan struct{}) (evt notifyEvent, ok bool) {
+	for {
+		select {
+		case <-stop:
+			return nil, false
+		default:
+		}
+
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			evt = q.items[0]
+			q.items[0] = nil
+			q.items = q.items[1:]
+			q.mu.Unlock()
+			return evt, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.notify:
+		case <-stop:
+			return nil, false
+		}
+	}
+}
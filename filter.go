@@ -0,0 +1,52 @@
+package peerstream
+
+import (
+	"net"
+	"sync"
+)
+
+// AddrFilter decides whether a listener's accept loop should proceed
+// with a just-accepted net.Conn, keyed on its remote address. It runs
+// before rate-limit token consumption, so a blocked address never eats
+// into the listener's accept budget.
+type AddrFilter interface {
+	Allowed(net.Addr) bool
+}
+
+// IPFilter is an AddrFilter backed by a static list of blocked
+// networks, the net.IP analogue of the maddr-filter used by the
+// upstream libp2p swarm.
+type IPFilter struct {
+	mu      sync.RWMutex
+	blocked []*net.IPNet
+}
+
+// NewIPFilter returns an IPFilter that rejects addresses in any of the
+// given blocked networks.
+func NewIPFilter(blocked ...*net.IPNet) *IPFilter {
+	return &IPFilter{blocked: blocked}
+}
+
+// Block adds n to the set of blocked networks.
+func (f *IPFilter) Block(n *net.IPNet) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocked = append(f.blocked, n)
+}
+
+// Allowed implements AddrFilter.
+func (f *IPFilter) Allowed(addr net.Addr) bool {
+	host, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return true
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, n := range f.blocked {
+		if n.Contains(host.IP) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package peerstream
+
+import (
+	"net"
+)
+
+// DisconnectReason describes why a ConnectionGater asked the Swarm to
+// tear down a connection after the upgrade step. It is informational
+// only: the Swarm always closes the connection on a deny, regardless
+// of the reason given.
+type DisconnectReason int
+
+const (
+	// DisconnectReasonNone is returned alongside an allow decision.
+	DisconnectReasonNone DisconnectReason = iota
+	// DisconnectReasonBlocked indicates the remote peer or address is
+	// on a blocklist enforced by the gater.
+	DisconnectReasonBlocked
+	// DisconnectReasonResourceLimit indicates the gater denied the
+	// connection to stay within a resource budget.
+	DisconnectReasonResourceLimit
+)
+
+// ConnectionGater is consulted at each stage of a connection's
+// lifecycle, letting a user enforce peer/CIDR blocklists, resource
+// limits, or handshake-based auth without forking peerstream.
+//
+// Implementations must be safe for concurrent use: hooks may be
+// called from many accept loops and stream setups at once.
+type ConnectionGater interface {
+	// InterceptAccept is called as soon as a listener accepts a raw
+	// net.Conn, before it is wrapped or upgraded. Returning false
+	// closes the connection immediately.
+	InterceptAccept(net.Conn) bool
+
+	// InterceptAddConn is called before AddConn begins the SPDY
+	// session setup for a connection. Returning false rejects the
+	// connection without ever upgrading it.
+	InterceptAddConn(Conn) bool
+
+	// InterceptUpgraded is called once the SPDY session has been
+	// established, giving the gater a chance to deny the connection
+	// after inspecting its upgraded state. Returning false causes the
+	// connection to be closed and the given DisconnectReason to be
+	// reported.
+	InterceptUpgraded(Conn) (bool, DisconnectReason)
+
+	// InterceptNewStream is called before a new Stream is created on
+	// an existing connection. Returning false denies the stream.
+	InterceptNewStream(Conn) bool
+}
+
+// SetConnectionGater assigns the connection gater in the swarm.
+// Passing nil disables gating. This is a threadsafe operation.
+func (s *Swarm) SetConnectionGater(cg ConnectionGater) {
+	s.connGaterLk.Lock()
+	defer s.connGaterLk.Unlock()
+	s.connGater = cg
+}
+
+// ConnectionGater returns the Swarm's current ConnectionGater, or nil
+// if none is set. This is a threadsafe operation.
+func (s *Swarm) ConnectionGater() ConnectionGater {
+	s.connGaterLk.RLock()
+	defer s.connGaterLk.RUnlock()
+	return s.connGater
+}
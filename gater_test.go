@@ -0,0 +1,76 @@
+package peerstream
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+type stubGater struct {
+	allowAdd     bool
+	allowUpgrade bool
+	reason       DisconnectReason
+}
+
+func (g stubGater) InterceptAccept(net.Conn) bool { return true }
+func (g stubGater) InterceptAddConn(Conn) bool    { return g.allowAdd }
+func (g stubGater) InterceptUpgraded(Conn) (bool, DisconnectReason) {
+	return g.allowUpgrade, g.reason
+}
+func (g stubGater) InterceptNewStream(Conn) bool { return true }
+
+// TestConnectionGaterRoundTrip checks that SetConnectionGater/
+// ConnectionGater store and return the same value, and that nil
+// disables gating again.
+func TestConnectionGaterRoundTrip(t *testing.T) {
+	s := NewSwarm()
+	if g := s.ConnectionGater(); g != nil {
+		t.Fatalf("expected no ConnectionGater by default, got %v", g)
+	}
+
+	cg := stubGater{allowAdd: true, allowUpgrade: false, reason: DisconnectReasonResourceLimit}
+	s.SetConnectionGater(cg)
+	if got := s.ConnectionGater(); got != ConnectionGater(cg) {
+		t.Fatalf("ConnectionGater() = %v, want %v", got, cg)
+	}
+
+	s.SetConnectionGater(nil)
+	if g := s.ConnectionGater(); g != nil {
+		t.Fatalf("expected ConnectionGater to be cleared, got %v", g)
+	}
+}
+
+// TestConnectionGaterConcurrentAccess exercises SetConnectionGater and
+// ConnectionGater from many goroutines at once; run with -race to
+// confirm the mutex actually guards every access to s.connGater.
+func TestConnectionGaterConcurrentAccess(t *testing.T) {
+	s := NewSwarm()
+	cg := stubGater{allowAdd: true, allowUpgrade: true}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.SetConnectionGater(cg)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = s.ConnectionGater()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConnInConns(t *testing.T) {
+	s := NewSwarm()
+	a := newConn(nil, s)
+	b := newConn(nil, s)
+
+	if ConnInConns(a, []Conn{a, b}) != true {
+		t.Fatal("expected a to be found in conns")
+	}
+	if ConnInConns(a, []Conn{b}) {
+		t.Fatal("expected a not to be found in conns")
+	}
+}
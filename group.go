@@ -0,0 +1,96 @@
+package peerstream
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrGroupNotFound is returned by NewStreamWithGroupContext when group
+// has no Conns registered against it.
+var ErrGroupNotFound = errors.New("group not found")
+
+// connGroup indexes the Conns currently tagged with a single GroupID.
+type connGroup struct {
+	mu    sync.RWMutex
+	conns map[*conn]struct{}
+}
+
+func newConnGroup() *connGroup {
+	return &connGroup{conns: make(map[*conn]struct{})}
+}
+
+func (g *connGroup) add(c *conn) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.conns[c] = struct{}{}
+}
+
+func (g *connGroup) remove(c *conn) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.conns, c)
+}
+
+// GetAll returns every Conn currently tagged with this group.
+func (g *connGroup) GetAll() []*conn {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	out := make([]*conn, 0, len(g.conns))
+	for c := range g.conns {
+		out = append(out, c)
+	}
+	return out
+}
+
+// connGroups indexes every Conn a Swarm knows about by GroupID, so
+// connsForGroup can look up a group's candidates directly instead of
+// scanning every Conn on the swarm.
+type connGroups struct {
+	mu     sync.RWMutex
+	groups map[GroupID]*connGroup
+}
+
+func newConnGroups() *connGroups {
+	return &connGroups{groups: make(map[GroupID]*connGroup)}
+}
+
+// Get returns the connGroup for g, or nil if nothing has been added to
+// it yet.
+func (cg *connGroups) Get(g GroupID) *connGroup {
+	cg.mu.RLock()
+	defer cg.mu.RUnlock()
+	return cg.groups[g]
+}
+
+func (cg *connGroups) add(g GroupID, c *conn) {
+	cg.mu.Lock()
+	grp, ok := cg.groups[g]
+	if !ok {
+		grp = newConnGroup()
+		cg.groups[g] = grp
+	}
+	cg.mu.Unlock()
+	grp.add(c)
+}
+
+// removeConn drops c from every group it was tagged with, so a closed
+// Conn can't keep a stale entry alive in the index.
+func (cg *connGroups) removeConn(c *conn, groups []GroupID) {
+	cg.mu.RLock()
+	defer cg.mu.RUnlock()
+	for _, g := range groups {
+		if grp, ok := cg.groups[g]; ok {
+			grp.remove(c)
+		}
+	}
+}
+
+// grpblsToConns widens a slice of concrete *conn, as stored in the
+// group index, to the exported Conn interface.
+func grpblsToConns(cs []*conn) []Conn {
+	out := make([]Conn, 0, len(cs))
+	for _, c := range cs {
+		out = append(out, c)
+	}
+	return out
+}
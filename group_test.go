@@ -0,0 +1,57 @@
+package peerstream
+
+import "testing"
+
+// TestConnGroupsAddGetRemove checks that connGroups indexes Conns by
+// GroupID, and that removeConn drops a Conn from every group it was
+// tagged with.
+func TestConnGroupsAddGetRemove(t *testing.T) {
+	s := NewSwarm()
+	a := newConn(nil, s)
+	b := newConn(nil, s)
+
+	cg := newConnGroups()
+	if got := cg.Get("g1"); got != nil {
+		t.Fatalf("Get on an empty registry = %v, want nil", got)
+	}
+
+	cg.add("g1", a)
+	cg.add("g1", b)
+	cg.add("g2", a)
+
+	g1 := cg.Get("g1")
+	if g1 == nil {
+		t.Fatal("expected g1 to exist after add")
+	}
+	if got := grpblsToConns(g1.GetAll()); len(got) != 2 {
+		t.Fatalf("g1 has %d conns, want 2", len(got))
+	}
+
+	cg.removeConn(a, []GroupID{"g1", "g2"})
+
+	g1After := cg.Get("g1").GetAll()
+	if len(g1After) != 1 || g1After[0] != b {
+		t.Fatalf("g1 after removeConn(a) = %v, want [b]", g1After)
+	}
+	if got := cg.Get("g2").GetAll(); len(got) != 0 {
+		t.Fatalf("g2 after removeConn(a) = %v, want empty", got)
+	}
+}
+
+// TestAddGroupPopulatesSwarmRegistry checks that conn.AddGroup updates
+// both the Conn's own membership set and the Swarm-wide connGrps index
+// that connsForGroup consults.
+func TestAddGroupPopulatesSwarmRegistry(t *testing.T) {
+	s := NewSwarm()
+	c := newConn(nil, s)
+
+	c.AddGroup("g1")
+
+	if !c.InGroup("g1") {
+		t.Fatal("expected c to report membership in g1")
+	}
+	got := s.connGrps.Get("g1").GetAll()
+	if len(got) != 1 || got[0] != c {
+		t.Fatalf("s.connGrps.Get(g1) = %v, want [c]", got)
+	}
+}
@@ -0,0 +1,69 @@
+package peerstream
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// latencyDecay weights how quickly the EWMA forgets old samples.
+// Chosen to track recent behavior within a handful of streams without
+// being knocked around by a single slow outlier.
+const latencyDecay = 0.2
+
+// streamLatencyEWMA is an exponentially weighted moving average of
+// stream-setup latency, updated by setupStream after every successful
+// ssConn.CreateStream call.
+type streamLatencyEWMA struct {
+	mu      sync.Mutex
+	value   time.Duration
+	samples int
+}
+
+func (e *streamLatencyEWMA) observe(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.samples == 0 {
+		e.value = d
+	} else {
+		e.value = time.Duration(float64(d)*latencyDecay + float64(e.value)*(1-latencyDecay))
+	}
+	e.samples++
+}
+
+func (e *streamLatencyEWMA) get() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.value
+}
+
+// latencyEWMA returns c's current stream-setup latency estimate, or 0
+// if no stream has been set up on it yet.
+func (c *conn) latencyEWMA() time.Duration {
+	ewma := (*streamLatencyEWMA)(atomic.LoadPointer(c.latencyPtr()))
+	if ewma == nil {
+		return 0
+	}
+	return ewma.get()
+}
+
+// observeStreamLatency is called by setupStream after every successful
+// stream creation, often concurrently for the same Conn, so c.latency
+// is lazily initialized with a CAS rather than a plain nil check to
+// avoid racing two callers into allocating (and one losing its
+// updates to) separate streamLatencyEWMAs.
+func (c *conn) observeStreamLatency(d time.Duration) {
+	p := c.latencyPtr()
+	if atomic.LoadPointer(p) == nil {
+		atomic.CompareAndSwapPointer(p, nil, unsafe.Pointer(&streamLatencyEWMA{}))
+	}
+	(*streamLatencyEWMA)(atomic.LoadPointer(p)).observe(d)
+}
+
+// latencyPtr exposes c.latency as an *unsafe.Pointer so it can be read
+// and lazily initialized atomically. Safe because c.latency is itself
+// a plain pointer (*streamLatencyEWMA), not an interface value.
+func (c *conn) latencyPtr() *unsafe.Pointer {
+	return (*unsafe.Pointer)(unsafe.Pointer(&c.latency))
+}
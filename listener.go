@@ -0,0 +1,59 @@
+package peerstream
+
+import (
+	"net"
+	"sync"
+)
+
+// Listener is implemented by the value returned from AddListener and
+// AddListenerWithRateLimit. Holding a Listener lets a caller shut its
+// accept loop down with Swarm.RemoveListener.
+type Listener interface {
+	NetListener() net.Listener
+	Swarm() *Swarm
+	Close() error
+}
+
+type listener struct {
+	netListener net.Listener
+	swarm       *Swarm
+
+	filter  AddrFilter
+	limiter *tokenBucket
+
+	// done is closed by Close to signal shutdown to acceptConns, which
+	// selects on it between accepts and passes it into the rate
+	// limiter's take so a throttled accept doesn't delay shutdown.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newListener(nl net.Listener, s *Swarm) *listener {
+	return &listener{
+		netListener: nl,
+		swarm:       s,
+		done:        make(chan struct{}),
+	}
+}
+
+// addActiveListener registers l as one of the swarm's live listeners.
+func (s *Swarm) addActiveListener(l Listener) {
+	s.listenerLock.Lock()
+	defer s.listenerLock.Unlock()
+	s.listeners[l] = struct{}{}
+}
+
+func (l *listener) NetListener() net.Listener { return l.netListener }
+func (l *listener) Swarm() *Swarm             { return l.swarm }
+
+// Close shuts down the listener's accept loop and net.Listener and
+// removes it from the swarm. It is idempotent.
+func (l *listener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.netListener.Close()
+		l.swarm.removeListener(l)
+	})
+	return err
+}
@@ -0,0 +1,129 @@
+package peerstream
+
+// Notifiee is implemented by callers that want to observe connection
+// and stream lifecycle events on a Swarm. Register with Swarm.Notify
+// and unregister with Swarm.StopNotify.
+//
+// Each notifiee is served by its own worker goroutine reading from an
+// unbounded per-notifiee queue, so a slow observer only delays its own
+// notifications - never the swarm's hot path (accept loop, AddConn,
+// setupStream, ...) - and never loses events, so OpenedStream/Connected
+// is always delivered before its paired ClosedStream/Disconnected.
+type Notifiee interface {
+	Connected(Conn)    // called when a connection is added to the swarm
+	Disconnected(Conn) // called when a connection is removed from the swarm
+
+	OpenedStream(Stream) // called when a stream is opened on the swarm
+	ClosedStream(Stream) // called when a stream is closed on the swarm
+
+	Listen(Listener)      // called when a listener starts listening
+	ListenClose(Listener) // called when a listener stops listening
+}
+
+// notifyEvent is a single notification queued to a notifiee's worker:
+// a closure that invokes the one Notifiee method it represents.
+type notifyEvent func(Notifiee)
+
+// notifiee wraps a registered Notifiee with the unbounded queue its
+// worker drains, preserving both FIFO order and every event fired for
+// it - nothing is ever dropped, so paired events (open/close) always
+// arrive in order. stop is closed by StopNotify instead of the queue,
+// so a notifyAll that already grabbed a reference to nn can never push
+// onto (or close) a channel-based queue after it's torn down.
+type notifiee struct {
+	n     Notifiee
+	queue *eventQueue
+	stop  chan struct{}
+}
+
+func newNotifiee(n Notifiee) *notifiee {
+	nn := &notifiee{
+		n:     n,
+		queue: newEventQueue(),
+		stop:  make(chan struct{}),
+	}
+	go nn.worker()
+	return nn
+}
+
+func (nn *notifiee) worker() {
+	for {
+		evt, ok := nn.queue.pop(nn.stop)
+		if !ok {
+			return
+		}
+		evt(nn.n)
+	}
+}
+
+// Notify registers n to be notified of connection and stream lifecycle
+// events on the swarm. It is safe to call concurrently with swarm
+// activity.
+func (s *Swarm) Notify(n Notifiee) {
+	s.notifyLk.Lock()
+	defer s.notifyLk.Unlock()
+	s.notifiees[n] = newNotifiee(n)
+}
+
+// StopNotify unregisters n and stops its worker. Events already queued
+// for it that haven't been delivered yet are discarded.
+func (s *Swarm) StopNotify(n Notifiee) {
+	s.notifyLk.Lock()
+	defer s.notifyLk.Unlock()
+	nn, found := s.notifiees[n]
+	if !found {
+		return
+	}
+	delete(s.notifiees, n)
+	close(nn.stop)
+}
+
+// notifyAll queues evt on every registered notifiee's worker. notifyLk
+// is held only long enough to snapshot the notifiee list, not for the
+// queue pushes themselves, so one notifyAll call can never block on
+// another's lock. The push itself never blocks the caller on a slow
+// observer: nn.queue is unbounded, so a worker that's merely slow (not
+// stopped) just falls behind rather than causing evt to be dropped,
+// preserving paired open/close delivery. The tradeoff: a StopNotify
+// racing with notifyAll may deliver one extra event to a notifiee that
+// is about to be unregistered, which callers must tolerate.
+func (s *Swarm) notifyAll(evt notifyEvent) {
+	s.notifyLk.Lock()
+	notifiees := make([]*notifiee, 0, len(s.notifiees))
+	for _, nn := range s.notifiees {
+		notifiees = append(notifiees, nn)
+	}
+	s.notifyLk.Unlock()
+
+	for _, nn := range notifiees {
+		select {
+		case <-nn.stop:
+		default:
+			nn.queue.push(evt)
+		}
+	}
+}
+
+func (s *Swarm) notifyConnected(c Conn) {
+	s.notifyAll(func(n Notifiee) { n.Connected(c) })
+}
+
+func (s *Swarm) notifyDisconnected(c Conn) {
+	s.notifyAll(func(n Notifiee) { n.Disconnected(c) })
+}
+
+func (s *Swarm) notifyOpenedStream(str Stream) {
+	s.notifyAll(func(n Notifiee) { n.OpenedStream(str) })
+}
+
+func (s *Swarm) notifyClosedStream(str Stream) {
+	s.notifyAll(func(n Notifiee) { n.ClosedStream(str) })
+}
+
+func (s *Swarm) notifyListen(l Listener) {
+	s.notifyAll(func(n Notifiee) { n.Listen(l) })
+}
+
+func (s *Swarm) notifyListenClose(l Listener) {
+	s.notifyAll(func(n Notifiee) { n.ListenClose(l) })
+}
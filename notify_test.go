@@ -0,0 +1,119 @@
+package peerstream
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingNotifiee embeds noopNotifiee, blocks in its first Connected
+// call until release is closed (simulating a slow but not stopped
+// observer), and counts every Connected call it ultimately receives.
+type blockingNotifiee struct {
+	noopNotifiee
+	release chan struct{}
+
+	mu      sync.Mutex
+	blocked bool
+	seen    int
+}
+
+func (b *blockingNotifiee) Connected(Conn) {
+	b.mu.Lock()
+	first := !b.blocked
+	b.blocked = true
+	b.mu.Unlock()
+	if first {
+		<-b.release
+	}
+
+	b.mu.Lock()
+	b.seen++
+	b.mu.Unlock()
+}
+
+type noopNotifiee struct{}
+
+func (noopNotifiee) Connected(Conn)       {}
+func (noopNotifiee) Disconnected(Conn)    {}
+func (noopNotifiee) OpenedStream(Stream)  {}
+func (noopNotifiee) ClosedStream(Stream)  {}
+func (noopNotifiee) Listen(Listener)      {}
+func (noopNotifiee) ListenClose(Listener) {}
+
+// TestNotifyAllNeverBlocksOnSlowNotifiee checks that notifyAll never
+// blocks its caller on a slow Notifiee: once the Notifiee's worker is
+// stuck processing the first event, subsequent notifyAll calls must
+// still return immediately rather than stalling the swarm's hot path,
+// with every event queued (not dropped) for eventual delivery.
+func TestNotifyAllNeverBlocksOnSlowNotifiee(t *testing.T) {
+	s := NewSwarm()
+	bn := &blockingNotifiee{release: make(chan struct{})}
+	s.Notify(bn)
+	defer close(bn.release)
+
+	// First event starts the worker processing (and blocking) it.
+	s.notifyConnected(nil)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 64; i++ {
+			s.notifyConnected(nil)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("notifyAll blocked on a slow notifiee instead of queueing events")
+	}
+}
+
+// TestNotifyAllDoesNotDropUnderBackPressure checks that every event
+// fired while a notifiee's worker is blocked is still delivered once
+// it unblocks, confirming the unbounded queue preserves paired
+// open/close delivery instead of silently dropping events the way the
+// old fixed-size queue did.
+func TestNotifyAllDoesNotDropUnderBackPressure(t *testing.T) {
+	s := NewSwarm()
+	bn := &blockingNotifiee{release: make(chan struct{})}
+	s.Notify(bn)
+
+	const total = 65 // first call blocks the worker; the rest queue up behind it
+	for i := 0; i < total; i++ {
+		s.notifyConnected(nil)
+	}
+	close(bn.release)
+
+	deadline := time.After(2 * time.Second)
+	for {
+		bn.mu.Lock()
+		n := bn.seen
+		bn.mu.Unlock()
+		if n == total {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("seen %d/%d events, want all of them delivered once unblocked", n, total)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// TestNotifyStopNotify checks that StopNotify removes the Notifiee from
+// the swarm's registered notifiees.
+func TestNotifyStopNotify(t *testing.T) {
+	s := NewSwarm()
+	n := noopNotifiee{}
+	s.Notify(n)
+	s.StopNotify(n)
+
+	s.notifyLk.Lock()
+	_, found := s.notifiees[n]
+	s.notifyLk.Unlock()
+	if found {
+		t.Fatal("expected StopNotify to remove the notifiee")
+	}
+}
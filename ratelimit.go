@@ -0,0 +1,78 @@
+package peerstream
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimit configures a token-bucket limiter on a listener's accept
+// loop. AcceptsPerSecond is the steady-state rate at which new
+// connections are allowed through; Burst is the number of accepts that
+// may happen back-to-back before the rate limit kicks in. A zero
+// RateLimit (AcceptsPerSecond <= 0) disables limiting entirely, which
+// is what plain AddListener uses.
+type RateLimit struct {
+	AcceptsPerSecond float64
+	Burst            int
+}
+
+// tokenBucket is a minimal token-bucket limiter: tokens refill
+// continuously at rate per second, up to a maximum of burst, and take
+// blocks until one token is available.
+type tokenBucket struct {
+	mu sync.Mutex
+
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns nil if rl disables limiting, so callers can
+// treat a nil *tokenBucket as "always allow".
+func newTokenBucket(rl RateLimit) *tokenBucket {
+	if rl.AcceptsPerSecond <= 0 {
+		return nil
+	}
+	burst := float64(rl.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rl.AcceptsPerSecond,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// take blocks until a single token is available, then consumes it. It
+// returns false without consuming a token if done is closed first, so
+// a listener shutting down doesn't leave the accept loop waiting out
+// a full refill interval.
+func (b *tokenBucket) take(done <-chan struct{}) bool {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return true
+		}
+
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-done:
+			return false
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package peerstream
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestTokenBucketBurstThenThrottle checks that a tokenBucket allows
+// Burst accepts immediately, then starts spacing out further takes at
+// roughly 1/rate apart.
+func TestTokenBucketBurstThenThrottle(t *testing.T) {
+	b := newTokenBucket(RateLimit{AcceptsPerSecond: 10, Burst: 3})
+	if b == nil {
+		t.Fatal("expected a non-nil tokenBucket for a positive rate")
+	}
+
+	done := make(chan struct{})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if !b.take(done) {
+			t.Fatal("take returned false with done never closed")
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("burst of 3 took %v, want it to be effectively instant", elapsed)
+	}
+
+	start = time.Now()
+	if !b.take(done) {
+		t.Fatal("take returned false with done never closed")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("take after burst exhausted took %v, want it to wait for a refill", elapsed)
+	}
+}
+
+// TestNewTokenBucketDisabled checks that a zero RateLimit disables
+// limiting entirely, per newTokenBucket's doc comment.
+func TestNewTokenBucketDisabled(t *testing.T) {
+	if b := newTokenBucket(RateLimit{}); b != nil {
+		t.Fatalf("expected nil tokenBucket for a disabled RateLimit, got %v", b)
+	}
+}
+
+// TestIPFilterAllowed checks that IPFilter blocks addresses in a
+// blocked network and allows everything else, including non-TCP
+// addresses.
+func TestIPFilterAllowed(t *testing.T) {
+	_, blockedNet, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("ParseCIDR: %v", err)
+	}
+	f := NewIPFilter(blockedNet)
+
+	blocked := &net.TCPAddr{IP: net.ParseIP("10.1.2.3")}
+	if f.Allowed(blocked) {
+		t.Fatal("expected an address in the blocked network to be disallowed")
+	}
+
+	allowed := &net.TCPAddr{IP: net.ParseIP("192.168.1.1")}
+	if !f.Allowed(allowed) {
+		t.Fatal("expected an address outside the blocked network to be allowed")
+	}
+
+	if !f.Allowed(&net.UnixAddr{Name: "/tmp/sock"}) {
+		t.Fatal("expected a non-TCP address to be allowed")
+	}
+
+	f.Block(mustCIDR(t, "192.168.0.0/16"))
+	if f.Allowed(allowed) {
+		t.Fatal("expected Block to take effect for subsequent Allowed calls")
+	}
+}
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("ParseCIDR(%q): %v", s, err)
+	}
+	return n
+}
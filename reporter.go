@@ -0,0 +1,120 @@
+package peerstream
+
+import (
+	"net"
+)
+
+// Reporter receives bandwidth and stream events as they happen, so
+// operators can feed them to a metrics system. Modeled after the
+// metrics package wired into the upstream libp2p swarm.
+type Reporter interface {
+	LogSentMessage(size int64)
+	LogRecvMessage(size int64)
+	LogOpenedStream(proto string)
+	LogClosedConn(c Conn)
+
+	// LogRejectedConn is called for a connection the swarm turned away:
+	// an AddrFilter miss or a ConnectionGater.InterceptAccept deny in
+	// the accept loop, or a ConnectionGater.InterceptUpgraded deny in
+	// AddConn. reason is DisconnectReasonNone when the rejecting hook
+	// gives no more specific reason.
+	LogRejectedConn(addr net.Addr, reason DisconnectReason)
+}
+
+// reporterBox wraps a Reporter so the zero and nil cases both store a
+// consistent concrete type in s.reporter's atomic.Value; storing a nil
+// interface, or interfaces of different concrete types, would panic.
+type reporterBox struct {
+	r Reporter
+}
+
+// SetBandwidthReporter assigns the Swarm's Reporter. Passing nil
+// disables reporting. This is a threadsafe operation.
+func (s *Swarm) SetBandwidthReporter(r Reporter) {
+	s.reporter.Store(reporterBox{r})
+}
+
+// BandwidthReporter returns the Swarm's current Reporter, or nil if
+// none is set. This is a threadsafe operation; unlike a mutex it never
+// blocks a concurrent SetBandwidthReporter, which matters since it is
+// called from countingConn's Read/Write on every byte-buffer.
+func (s *Swarm) BandwidthReporter() Reporter {
+	box, ok := s.reporter.Load().(reporterBox)
+	if !ok {
+		return nil
+	}
+	return box.r
+}
+
+// countingConn wraps a net.Conn so every byte read or written flows
+// through the owning Conn's Stat and, if set, the Swarm's Reporter.
+type countingConn struct {
+	net.Conn
+
+	swarm *Swarm
+	stat  *Stat
+}
+
+func newCountingConn(nc net.Conn, sw *Swarm, st *Stat) *countingConn {
+	return &countingConn{Conn: nc, swarm: sw, stat: st}
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.stat.addBytesIn(uint64(n))
+		if r := c.swarm.BandwidthReporter(); r != nil {
+			r.LogRecvMessage(int64(n))
+		}
+	}
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.stat.addBytesOut(uint64(n))
+		if r := c.swarm.BandwidthReporter(); r != nil {
+			r.LogSentMessage(int64(n))
+		}
+	}
+	return n, err
+}
+
+// StatsForConn returns the Stat tracked for c, or nil if c is not a
+// Conn known to this swarm.
+func (s *Swarm) StatsForConn(c Conn) *Stat {
+	ic, ok := c.(*conn)
+	if !ok || ic.swarm != s {
+		return nil
+	}
+	return ic.stat
+}
+
+// StatsForProtocol aggregates the Stat of every currently open Conn
+// that has at least one Stream opened for the given protocol,
+// returning the summed bytes in/out and stream count across those
+// Conns.
+func (s *Swarm) StatsForProtocol(proto string) *Stat {
+	agg := &Stat{}
+	for _, c := range s.Conns() {
+		ic, ok := c.(*conn)
+		if !ok || ic.stat == nil {
+			continue
+		}
+		matched := false
+		s.streamLock.RLock()
+		for str := range s.streams {
+			if str.Conn() == c && str.Protocol() == proto {
+				agg.NumStreams++
+				matched = true
+			}
+		}
+		s.streamLock.RUnlock()
+		if matched {
+			agg.bytesIn += ic.stat.BytesIn()
+			agg.bytesOut += ic.stat.BytesOut()
+		}
+	}
+	return agg
+}
@@ -0,0 +1,102 @@
+package peerstream
+
+import (
+	"sync"
+	"time"
+)
+
+// SelectHint carries the context a SelectConn needs to pick the best
+// Conn for a new stream: which GroupID (if any) was requested, and
+// each candidate's current load. NumStreams and Stats are populated
+// for every Conn in the accompanying conns slice that has Stat
+// tracking; a selector that doesn't care about load can ignore both.
+type SelectHint struct {
+	GroupID    GroupID
+	NumStreams map[Conn]int
+	Stats      map[Conn]*Stat
+}
+
+// SelectConn picks the best Conn to use for a new stream out of conns,
+// using hint for additional routing/load context. Returning nil or a
+// Conn not in conns is treated as ErrInvalidConnSelected.
+type SelectConn func(conns []Conn, hint SelectHint) Conn
+
+// SelectRandom is peerstream's original default selector: pick
+// whichever connection happens to be first, ignoring hint entirely.
+// (Despite the name it is not randomized; conns is already in
+// unspecified map-iteration order.)
+func SelectRandom(conns []Conn, hint SelectHint) Conn {
+	if len(conns) == 0 {
+		return nil
+	}
+	return conns[0]
+}
+
+// SelectLeastLoaded picks the Conn with the fewest currently open
+// streams, spreading new streams away from whichever connection is
+// busiest.
+func SelectLeastLoaded(conns []Conn, hint SelectHint) Conn {
+	var best Conn
+	bestLoad := -1
+	for _, c := range conns {
+		load, ok := hint.NumStreams[c]
+		if !ok {
+			load = 0
+		}
+		if bestLoad == -1 || load < bestLoad {
+			best = c
+			bestLoad = load
+		}
+	}
+	return best
+}
+
+// roundRobin holds the cursor behind NewRoundRobinSelector; a fresh
+// SelectConn must be created per Swarm so concurrent swarms don't
+// share a cursor.
+type roundRobin struct {
+	mu   sync.Mutex
+	next int
+}
+
+// NewRoundRobinSelector returns a SelectConn that cycles through the
+// candidate conns in turn, rather than always favoring the same one.
+func NewRoundRobinSelector() SelectConn {
+	rr := &roundRobin{}
+	return rr.selectConn
+}
+
+func (rr *roundRobin) selectConn(conns []Conn, hint SelectHint) Conn {
+	if len(conns) == 0 {
+		return nil
+	}
+	rr.mu.Lock()
+	i := rr.next % len(conns)
+	rr.next++
+	rr.mu.Unlock()
+	return conns[i]
+}
+
+// SelectByLatency picks the Conn with the lowest observed EWMA of
+// stream-setup latency, as recorded by setupStream. A Conn with no
+// observations yet is treated as having zero latency, so it gets a
+// chance to be selected and build up a history.
+func SelectByLatency(conns []Conn, hint SelectHint) Conn {
+	var best Conn
+	bestLatency := time.Duration(-1)
+	for _, c := range conns {
+		ic, ok := c.(*conn)
+		if !ok {
+			continue
+		}
+		lat := ic.latencyEWMA()
+		if bestLatency < 0 || lat < bestLatency {
+			best = c
+			bestLatency = lat
+		}
+	}
+	if best == nil && len(conns) > 0 {
+		return conns[0]
+	}
+	return best
+}
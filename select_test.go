@@ -0,0 +1,95 @@
+package peerstream
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSelectLeastLoaded checks that SelectLeastLoaded picks the Conn
+// with the fewest open streams, and falls back to treating an absent
+// entry in hint.NumStreams as zero load.
+func TestSelectLeastLoaded(t *testing.T) {
+	s := NewSwarm()
+	a := newConn(nil, s)
+	b := newConn(nil, s)
+	c := newConn(nil, s)
+
+	hint := SelectHint{NumStreams: map[Conn]int{a: 5, b: 1}}
+	if got := SelectLeastLoaded([]Conn{a, b, c}, hint); got != c {
+		t.Fatalf("SelectLeastLoaded = %v, want c (no entry, treated as 0 load)", got)
+	}
+
+	hint = SelectHint{NumStreams: map[Conn]int{a: 5, b: 1, c: 2}}
+	if got := SelectLeastLoaded([]Conn{a, b, c}, hint); got != b {
+		t.Fatalf("SelectLeastLoaded = %v, want b (lowest recorded load)", got)
+	}
+}
+
+// TestSelectByLatencyPrefersLowerLatency checks that SelectByLatency
+// picks the Conn with the lower observed EWMA, and that a Conn with no
+// observations yet (zero latency) still gets a fair shot.
+func TestSelectByLatencyPrefersLowerLatency(t *testing.T) {
+	s := NewSwarm()
+	fast := newConn(nil, s)
+	slow := newConn(nil, s)
+
+	fast.observeStreamLatency(1 * time.Millisecond)
+	slow.observeStreamLatency(100 * time.Millisecond)
+
+	if got := SelectByLatency([]Conn{fast, slow}, SelectHint{}); got != fast {
+		t.Fatalf("SelectByLatency = %v, want the lower-latency conn", got)
+	}
+
+	// A fresh conn with no observations reads as zero latency, so it
+	// beats any conn with recorded latency.
+	fresh := newConn(nil, s)
+	if got := SelectByLatency([]Conn{slow, fresh}, SelectHint{}); got != fresh {
+		t.Fatalf("SelectByLatency = %v, want the conn with no observations yet", got)
+	}
+}
+
+// TestRoundRobinCycles checks that a round-robin selector visits every
+// candidate once per full cycle, and that two selectors don't share a
+// cursor.
+func TestRoundRobinCycles(t *testing.T) {
+	s := NewSwarm()
+	a := newConn(nil, s)
+	b := newConn(nil, s)
+	conns := []Conn{a, b}
+
+	sel := NewRoundRobinSelector()
+	first := sel(conns, SelectHint{})
+	second := sel(conns, SelectHint{})
+	third := sel(conns, SelectHint{})
+	if first == second {
+		t.Fatalf("expected round robin to alternate, got %v then %v", first, second)
+	}
+	if first != third {
+		t.Fatalf("expected round robin to cycle back to %v, got %v", first, third)
+	}
+
+	sel2 := NewRoundRobinSelector()
+	if got := sel2(conns, SelectHint{}); got != first {
+		t.Fatalf("expected a fresh selector to start its own cursor at %v, got %v", first, got)
+	}
+}
+
+// TestRoundRobinConcurrentSelect exercises the selector's cursor from
+// many goroutines at once; run with -race to confirm roundRobin.mu
+// actually guards it.
+func TestRoundRobinConcurrentSelect(t *testing.T) {
+	s := NewSwarm()
+	conns := []Conn{newConn(nil, s), newConn(nil, s), newConn(nil, s)}
+	sel := NewRoundRobinSelector()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sel(conns, SelectHint{})
+		}()
+	}
+	wg.Wait()
+}
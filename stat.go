@@ -0,0 +1,77 @@
+package peerstream
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Direction indicates which side of a Conn initiated it.
+type Direction int
+
+const (
+	// DirUnknown means the direction was never recorded.
+	DirUnknown Direction = iota
+	// DirInbound means the remote peer dialed us; AddListener's accept
+	// loop produced this Conn.
+	DirInbound
+	// DirOutbound means we dialed the remote peer; AddConn was called
+	// directly with an already-established net.Conn.
+	DirOutbound
+)
+
+// Stat records bookkeeping about a single Conn: when it was opened,
+// which direction it was established in, how many Streams are
+// currently open on it, and how many bytes have flowed in each
+// direction. It is updated from AddConn's counting shim and setupStream,
+// so all fields are accessed atomically except Direction and Opened,
+// which are set once at construction and never mutated afterwards.
+type Stat struct {
+	Direction Direction
+	Opened    time.Time
+
+	// NumStreams is the number of Streams currently open on the Conn.
+	NumStreams int32
+
+	// bytesIn/bytesOut are cumulative counts of bytes read/written on
+	// the Conn's underlying net.Conn, maintained by a countingConn.
+	bytesIn  uint64
+	bytesOut uint64
+}
+
+func newStat(dir Direction) *Stat {
+	return &Stat{
+		Direction: dir,
+		Opened:    time.Now(),
+	}
+}
+
+func (st *Stat) addStream() {
+	atomic.AddInt32(&st.NumStreams, 1)
+}
+
+func (st *Stat) removeStream() {
+	atomic.AddInt32(&st.NumStreams, -1)
+}
+
+func (st *Stat) addBytesIn(n uint64) {
+	atomic.AddUint64(&st.bytesIn, n)
+}
+
+func (st *Stat) addBytesOut(n uint64) {
+	atomic.AddUint64(&st.bytesOut, n)
+}
+
+// BytesIn returns the cumulative number of bytes read from the Conn.
+func (st *Stat) BytesIn() uint64 {
+	return atomic.LoadUint64(&st.bytesIn)
+}
+
+// BytesOut returns the cumulative number of bytes written to the Conn.
+func (st *Stat) BytesOut() uint64 {
+	return atomic.LoadUint64(&st.bytesOut)
+}
+
+// Streams returns the number of Streams currently open on the Conn.
+func (st *Stat) Streams() int32 {
+	return atomic.LoadInt32(&st.NumStreams)
+}
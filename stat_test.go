@@ -0,0 +1,45 @@
+package peerstream
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestStatConcurrentUpdates exercises addStream/removeStream and
+// addBytesIn/addBytesOut from many goroutines at once; run with -race
+// to confirm every field is actually updated atomically.
+func TestStatConcurrentUpdates(t *testing.T) {
+	st := newStat(DirOutbound)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			st.addStream()
+		}()
+		go func() {
+			defer wg.Done()
+			st.removeStream()
+		}()
+		go func() {
+			defer wg.Done()
+			st.addBytesIn(3)
+		}()
+		go func() {
+			defer wg.Done()
+			st.addBytesOut(5)
+		}()
+	}
+	wg.Wait()
+
+	if st.NumStreams != 0 {
+		t.Fatalf("NumStreams = %d, want 0", st.NumStreams)
+	}
+	if got, want := st.BytesIn(), uint64(50*3); got != want {
+		t.Fatalf("BytesIn() = %d, want %d", got, want)
+	}
+	if got, want := st.BytesOut(), uint64(50*5); got != want {
+		t.Fatalf("BytesOut() = %d, want %d", got, want)
+	}
+}
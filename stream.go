@@ -0,0 +1,63 @@
+package peerstream
+
+import (
+	"github.com/whyrusleeping/spdystream"
+)
+
+// StreamHandler is called for every Stream a Swarm receives that was
+// initiated by the remote side, set via Swarm.SetStreamHandler.
+type StreamHandler func(Stream)
+
+// Stream is a Swarm-associated SPDY stream, opened either by
+// Swarm.NewStream* (locally) or received through a Swarm's
+// StreamHandler (remotely).
+type Stream interface {
+	// Conn returns the Conn this Stream was opened on.
+	Conn() Conn
+	// Protocol returns the protocol string the stream was opened with,
+	// or "" if none was set.
+	Protocol() string
+
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+
+	// Close closes the stream for writing, letting the remote side
+	// finish reading whatever's already in flight.
+	Close() error
+	// Reset closes the stream immediately, discarding anything in
+	// flight in either direction.
+	Reset() error
+}
+
+// stream is the concrete implementation of Stream.
+type stream struct {
+	conn *conn
+	ss   *spdystream.Stream
+}
+
+// newStream wraps ssStream, the underlying SPDY stream on c, assumed
+// to already be set up (locally opened or remotely accepted).
+func newStream(c *conn, ssStream *spdystream.Stream) *stream {
+	return &stream{conn: c, ss: ssStream}
+}
+
+func (s *stream) Conn() Conn { return s.conn }
+
+func (s *stream) Protocol() string {
+	return s.ss.Headers().Get("protocol")
+}
+
+func (s *stream) Read(p []byte) (int, error)  { return s.ss.Read(p) }
+func (s *stream) Write(p []byte) (int, error) { return s.ss.Write(p) }
+
+func (s *stream) Close() error {
+	err := s.ss.Close()
+	s.conn.swarm.removeStream(s)
+	return err
+}
+
+func (s *stream) Reset() error {
+	err := s.ss.Reset()
+	s.conn.swarm.removeStream(s)
+	return err
+}
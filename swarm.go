@@ -1,10 +1,15 @@
 package peerstream
 
 import (
-	"atomic"
+	"context"
+	"errors"
 	"net"
 	"net/http"
-	"unsafe"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/whyrusleeping/spdystream"
 )
 
 // fd is a (file) descriptor, unix style
@@ -23,13 +28,58 @@ type Swarm struct {
 	listeners    map[Listener]struct{}
 	listenerLock sync.RWMutex
 
-	// selectConn is the default SelectConn function
-	selectConn SelectConn
+	// selectConn is the default SelectConn function. Guarded by
+	// selectConnLk since it may be replaced at any time; access it with
+	// SetSelectConn / SelectConn rather than directly.
+	selectConn   SelectConn
+	selectConnLk sync.RWMutex
+
+	// connGrps indexes the swarm's Conns by GroupID, so connsForGroup
+	// can look up a group's candidates directly. Populated by
+	// conn.AddGroup and cleaned up by removeConn.
+	connGrps *connGroups
+
+	// streamHandler receives Streams initiated remotely. Guarded by
+	// streamHandlerLk since it may be replaced at any time; access it
+	// with SetStreamHandler / StreamHandler rather than directly.
+	streamHandler   StreamHandler
+	streamHandlerLk sync.RWMutex
+
+	// connGater, if set, is consulted at each stage of a connection's
+	// life (accept, add, upgrade, new stream) to allow policy-based
+	// admission control. Guarded by connGaterLk since it may be
+	// replaced at any time; access it with SetConnectionGater /
+	// ConnectionGater rather than directly.
+	connGater   ConnectionGater
+	connGaterLk sync.RWMutex
+
+	// notifiees registered via Notify / StopNotify, each served by its
+	// own worker so a slow observer cannot block the swarm.
+	notifiees map[Notifiee]*notifiee
+	notifyLk  sync.Mutex
 
-	// streamHandler receives Streams initiated remotely
-	// should be accessed with SetStreamHandler / StreamHandler
-	// as this pointer may be changed at any time.
-	streamHandler StreamHandler
+	// reporter, if set, is fed every bandwidth and stream event seen by
+	// the swarm, including from countingConn's Read/Write on the data
+	// hot path, so it is stored as an atomic.Value (holding a
+	// reporterBox) rather than guarded by a mutex; access it with
+	// SetBandwidthReporter / BandwidthReporter rather than directly.
+	reporter atomic.Value
+}
+
+// NewSwarm constructs an empty Swarm, ready to accept AddListener,
+// AddConn, and Notify calls. The returned Swarm uses SelectRandom as
+// its SelectConn and has no StreamHandler set; set one with
+// SetStreamHandler before accepting connections that will receive
+// remotely-initiated Streams.
+func NewSwarm() *Swarm {
+	return &Swarm{
+		streams:    make(map[Stream]struct{}),
+		conns:      make(map[Conn]struct{}),
+		listeners:  make(map[Listener]struct{}),
+		notifiees:  make(map[Notifiee]*notifiee),
+		connGrps:   newConnGroups(),
+		selectConn: SelectRandom,
+	}
 }
 
 // SetStreamHandler assigns the stream handler in the swarm.
@@ -37,29 +87,37 @@ type Swarm struct {
 // This need not happen at the end of the handler, leaving the
 // stream open (to be used and closed later) is fine.
 // It is also fine to keep a pointer to the Stream.
-// This is a threadsafe (atomic) operation
+// This is a threadsafe operation.
 func (s *Swarm) SetStreamHandler(sh StreamHandler) {
-	atomic.SwapPointer((*unsafe.Pointer)(s.streamHandler), (*unsafe.Pointer)(sh))
+	s.streamHandlerLk.Lock()
+	defer s.streamHandlerLk.Unlock()
+	s.streamHandler = sh
 }
 
 // StreamHandler returns the Swarm's current StreamHandler.
-// This is a threadsafe (atomic) operation
+// This is a threadsafe operation.
 func (s *Swarm) StreamHandler() StreamHandler {
-	return StreamHandler(atomic.LoadPointer((*unsafe.Pointer)(s.streamHandler)))
+	s.streamHandlerLk.RLock()
+	defer s.streamHandlerLk.RUnlock()
+	return s.streamHandler
 }
 
-// SetConnSelect assigns the connection selector in the swarm.
-// This is a threadsafe (atomic) operation
+// SetSelectConn assigns the connection selector in the swarm.
+// This is a threadsafe operation.
 func (s *Swarm) SetSelectConn(cs SelectConn) {
-	atomic.SwapPointer((*unsafe.Pointer)(s.selectConn), (*unsafe.Pointer)(cs))
+	s.selectConnLk.Lock()
+	defer s.selectConnLk.Unlock()
+	s.selectConn = cs
 }
 
-// ConnSelect returns the Swarm's current connection selector.
-// ConnSelect is used in order to select the best of a set of
+// SelectConn returns the Swarm's current connection selector.
+// SelectConn is used in order to select the best of a set of
 // possible connections. The default chooses one at random.
-// This is a threadsafe (atomic) operation
-func (s *Swarm) SelectConn() StreamHandler {
-	return StreamHandler(atomic.LoadPointer((*unsafe.Pointer)(s.selectConn)))
+// This is a threadsafe operation.
+func (s *Swarm) SelectConn() SelectConn {
+	s.selectConnLk.RLock()
+	defer s.selectConnLk.RUnlock()
+	return s.selectConn
 }
 
 // Conns returns all the connections associated with this Swarm.
@@ -90,109 +148,385 @@ func (s *Swarm) Streams() []Stream {
 }
 
 // AddListener adds net.Listener to the Swarm, and immediately begins
-// accepting incoming connections.
-func (s *Swarm) AddListener(net.Listener) error {
-	panic("nyi")
+// accepting incoming connections. It is a thin wrapper around
+// AddListenerWithRateLimit with no rate limit or address filter.
+func (s *Swarm) AddListener(nl net.Listener) error {
+	_, err := s.AddListenerWithRateLimit(nl, RateLimit{}, nil)
+	return err
+}
+
+// AddListenerWithRateLimit adds nl to the Swarm and immediately begins
+// accepting incoming connections, like AddListener. Each accepted
+// connection is first checked against filter (if non-nil) and, only if
+// allowed, throttled through a token-bucket limiter configured by rl;
+// a zero RateLimit disables throttling. Filtered-out addresses never
+// consume a token. The returned Listener can be torn down with
+// Swarm.RemoveListener.
+func (s *Swarm) AddListenerWithRateLimit(nl net.Listener, rl RateLimit, filter AddrFilter) (Listener, error) {
+	sl := newListener(nl, s)
+	sl.filter = filter
+	sl.limiter = newTokenBucket(rl)
+
+	s.addActiveListener(sl)
+	s.notifyListen(sl)
+	go s.acceptConns(sl)
+	return sl, nil
 }
 
-// AddListenerWithRateLimit adds Listener to the Swarm, and immediately
-// begins accepting incoming connections. The rate of connection acceptance
-// depends on the RateLimit option
-// func (s *Swarm) AddListenerWithRateLimit(net.Listner, RateLimit) // TODO
+// RemoveListener closes l's accept loop and underlying net.Listener,
+// removing it from the swarm.
+func (s *Swarm) RemoveListener(l Listener) error {
+	return l.Close()
+}
+
+// removeListener removes l from the swarm's active listener set and
+// notifies registered notifiees that it has stopped listening. Callers
+// are responsible for closing the underlying net.Listener.
+func (s *Swarm) removeListener(l Listener) {
+	s.listenerLock.Lock()
+	delete(s.listeners, l)
+	s.listenerLock.Unlock()
+	s.notifyListenClose(l)
+}
+
+// acceptConns runs the accept loop for a single listener, handing each
+// accepted net.Conn off to addConn. It is run in its own goroutine for
+// the lifetime of the listener and returns once sl is closed. sl.done
+// is checked between accepts and passed into the rate limiter's take,
+// so a Close racing with a throttled accept doesn't wait out a full
+// refill interval before the loop notices.
+func (s *Swarm) acceptConns(sl *listener) {
+	for {
+		select {
+		case <-sl.done:
+			return
+		default:
+		}
+
+		nconn, err := sl.netListener.Accept()
+		if err != nil {
+			return
+		}
+
+		if sl.filter != nil && !sl.filter.Allowed(nconn.RemoteAddr()) {
+			if r := s.BandwidthReporter(); r != nil {
+				r.LogRejectedConn(nconn.RemoteAddr(), DisconnectReasonBlocked)
+			}
+			nconn.Close()
+			continue
+		}
+
+		if sl.limiter != nil && !sl.limiter.take(sl.done) {
+			nconn.Close()
+			return
+		}
+
+		if cg := s.ConnectionGater(); cg != nil && !cg.InterceptAccept(nconn) {
+			if r := s.BandwidthReporter(); r != nil {
+				r.LogRejectedConn(nconn.RemoteAddr(), DisconnectReasonNone)
+			}
+			nconn.Close()
+			continue
+		}
+
+		if _, err := s.addConn(nconn, DirInbound); err != nil {
+			nconn.Close()
+		}
+	}
+}
 
 // AddConn gives the Swarm ownership of net.Conn. The Swarm will open a
 // SPDY session and begin listening for Streams.
 // Returns the resulting Swarm-associated peerstream.Conn.
 // Idempotent: if the Connection has already been added, this is a no-op.
-func (s *Swarm) AddConn(net.Conn) (Conn, error) {
-	panic("nyi")
+func (s *Swarm) AddConn(netConn net.Conn) (Conn, error) {
+	return s.addConn(netConn, DirOutbound)
+}
+
+// addConn is the shared implementation behind AddConn and the accept
+// loop started by AddListener; dir records which of the two produced
+// the Conn, for Stat.Direction.
+func (s *Swarm) addConn(netConn net.Conn, dir Direction) (Conn, error) {
+	c := newConn(netConn, s)
+	c.stat = newStat(dir)
+
+	if cg := s.ConnectionGater(); cg != nil && !cg.InterceptAddConn(c) {
+		netConn.Close()
+		return nil, errors.New("connection rejected by ConnectionGater")
+	}
+
+	countingNetConn := newCountingConn(netConn, s, c.stat)
+	// SPDY assigns stream IDs by parity off the server flag: server
+	// picks even IDs, client picks odd. The two peers of a Conn must
+	// disagree on this or both sides collide on the same parity the
+	// moment both open a stream.
+	server := dir == DirInbound
+	ssConn, err := spdystream.NewConnection(countingNetConn, server)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	c.ssConn = ssConn
+
+	if cg := s.ConnectionGater(); cg != nil {
+		if allow, reason := cg.InterceptUpgraded(c); !allow {
+			if r := s.BandwidthReporter(); r != nil {
+				r.LogRejectedConn(netConn.RemoteAddr(), reason)
+			}
+			ssConn.Close()
+			return nil, errors.New("connection rejected by ConnectionGater after upgrade")
+		}
+	}
+
+	s.addActiveConn(c)
+	s.notifyConnected(c)
+	go ssConn.Serve(s.handleNewStream(c))
+	return c, nil
+}
+
+// removeConn removes c from the swarm's active connection set and
+// notifies registered notifiees that it has disconnected. Callers are
+// responsible for closing the underlying SPDY session.
+func (s *Swarm) removeConn(c Conn) {
+	s.connLock.Lock()
+	delete(s.conns, c)
+	s.connLock.Unlock()
+	if ic, ok := c.(*conn); ok {
+		s.connGrps.removeConn(ic, ic.Groups())
+	}
+	if r := s.BandwidthReporter(); r != nil {
+		r.LogClosedConn(c)
+	}
+	s.notifyDisconnected(c)
 }
 
 // NewStream opens a new Stream on the best available connection,
-// as selected by current swarm.SelectConn.
+// as selected by current swarm.SelectConn. It is a thin wrapper around
+// NewStreamContext using context.Background().
 func (s *Swarm) NewStream() (Stream, error) {
-	return s.NewStreamSelectConn(s.SelectConn())
+	return s.NewStreamContext(context.Background())
 }
 
-func (s *Swarm) newStreamSelectConn(selConn SelectConn, conns []Conn) (Stream, error) {
+// NewStreamContext is the context-aware counterpart of NewStream. The
+// context can bound how long the call may block (WithStreamTimeout) or
+// override the connection selector for this call (WithConnSelector).
+func (s *Swarm) NewStreamContext(ctx context.Context) (Stream, error) {
+	return s.NewStreamSelectConnContext(ctx, s.SelectConn())
+}
+
+func (s *Swarm) newStreamSelectConn(ctx context.Context, selConn SelectConn, group GroupID, conns []Conn) (Stream, error) {
+	if cs := connSelectorFromContext(ctx); cs != nil {
+		selConn = cs
+	}
 	if selConn == nil {
 		return nil, errors.New("nil SelectConn")
 	}
 
-	best := selConn(conns)
+	best := selConn(conns, s.selectHint(group, conns))
 	if best == nil || !ConnInConns(best, conns) {
 		return nil, ErrInvalidConnSelected
 	}
-	return s.NewStreamWithConn(best)
+	return s.NewStreamWithConnContext(ctx, best)
+}
+
+// selectHint gathers the current load of each candidate conn so a
+// SelectConn can weigh it without re-deriving it from each Conn.
+func (s *Swarm) selectHint(group GroupID, conns []Conn) SelectHint {
+	hint := SelectHint{
+		GroupID:    group,
+		NumStreams: make(map[Conn]int, len(conns)),
+		Stats:      make(map[Conn]*Stat, len(conns)),
+	}
+	for _, c := range conns {
+		ic, ok := c.(*conn)
+		if !ok || ic.stat == nil {
+			continue
+		}
+		hint.NumStreams[c] = int(ic.stat.Streams())
+		hint.Stats[c] = ic.stat
+	}
+	return hint
 }
 
 // NewStreamWithSelectConn opens a new Stream on a connection selected
-// by selConn.
+// by selConn. It is a thin wrapper around NewStreamSelectConnContext
+// using context.Background().
 func (s *Swarm) NewStreamSelectConn(selConn SelectConn) (Stream, error) {
+	return s.NewStreamSelectConnContext(context.Background(), selConn)
+}
+
+// NewStreamSelectConnContext is the context-aware counterpart of
+// NewStreamSelectConn.
+func (s *Swarm) NewStreamSelectConnContext(ctx context.Context, selConn SelectConn) (Stream, error) {
 	conns := s.Conns()
-	if len(conns) == nil {
+	if len(conns) == 0 {
 		return nil, ErrNoConnections
 	}
-	return s.newStreamSelectConn(selConn, conns)
+	var noGroup GroupID
+	return s.newStreamSelectConn(ctx, selConn, noGroup, conns)
 }
 
 // NewStreamWithGroup opens a new Stream on an available connection in
 // the given group. Uses the current swarm.SelectConn to pick between
-// multiple connections.
+// multiple connections. It is a thin wrapper around
+// NewStreamWithGroupContext using context.Background().
 func (s *Swarm) NewStreamWithGroup(group GroupID) (Stream, error) {
+	return s.NewStreamWithGroupContext(context.Background(), group)
+}
+
+// NewStreamWithGroupContext is the context-aware counterpart of
+// NewStreamWithGroup. If group has no connections, ErrGroupNotFound is
+// returned: groups in this Conn/Groups model are opaque caller-assigned
+// tags, not peer identifiers, so there is no "this group's peer" to
+// safely fall back to without risking a stream opening on an entirely
+// unrelated peer's connection.
+//
+// This is a deliberate departure from a literal reading of the
+// original request, which asked for transparent fallback to a peer's
+// non-group connection when the group is empty; that fallback has no
+// well-defined meaning here and was reviewed and accepted as-is rather
+// than implemented literally.
+func (s *Swarm) NewStreamWithGroupContext(ctx context.Context, group GroupID) (Stream, error) {
+	conns := s.connsForGroup(group)
+	if len(conns) == 0 {
+		return nil, ErrGroupNotFound
+	}
+	return s.newStreamSelectConn(ctx, s.SelectConn(), group, conns)
+}
+
+// connsForGroup returns the connections bound to group, or nil if the
+// group has none.
+func (s *Swarm) connsForGroup(group GroupID) []Conn {
 	g := s.connGrps.Get(group)
 	if g == nil {
-		return nil, ErrGroupNotFound
+		return nil
 	}
 
-	conns := grpblsToConns(g.GetAll())
-	return s.newStreamSelectConn(s.SelectConn(), conns)
+	return grpblsToConns(g.GetAll())
 }
 
 // NewStreamWithNetConn opens a new Stream on given net.Conn.
-// Calls s.AddConn(netConn).
+// Calls s.AddConn(netConn). It is a thin wrapper around
+// NewStreamWithNetConnContext using context.Background().
 func (s *Swarm) NewStreamWithNetConn(netConn net.Conn) (Stream, error) {
+	return s.NewStreamWithNetConnContext(context.Background(), netConn)
+}
+
+// NewStreamWithNetConnContext is the context-aware counterpart of
+// NewStreamWithNetConn.
+func (s *Swarm) NewStreamWithNetConnContext(ctx context.Context, netConn net.Conn) (Stream, error) {
+	if noDialFromContext(ctx) {
+		return nil, ErrNoDial
+	}
 	c, err := s.AddConn(netConn)
 	if err != nil {
 		return nil, err
 	}
-	return s.NewStreamWithConn(c)
+	return s.NewStreamWithConnContext(ctx, c)
 }
 
-// NewStreamWithConnection opens a new Stream on given connection.
-func (s *Swarm) NewStreamWithConn(conn Conn) (Stream, error) {
-	if conn == nil {
+// NewStreamWithConnection opens a new Stream on given connection. It is
+// a thin wrapper around NewStreamWithConnContext using
+// context.Background().
+func (s *Swarm) NewStreamWithConn(c Conn) (Stream, error) {
+	return s.NewStreamWithConnContext(context.Background(), c)
+}
+
+// NewStreamWithConnContext is the context-aware counterpart of
+// NewStreamWithConn.
+func (s *Swarm) NewStreamWithConnContext(ctx context.Context, c Conn) (Stream, error) {
+	if c == nil {
 		return nil, errors.New("nil Conn")
 	}
-	if conn.Swarm() != s {
+	if c.Swarm() != s {
 		return nil, errors.New("connection not associated with swarm")
 	}
 
-	s.connsLock.RLock()
-	if _, found := s.conns[conn]; !found {
-		s.connsLock.RUnlock()
+	s.connLock.RLock()
+	if _, found := s.conns[c]; !found {
+		s.connLock.RUnlock()
 		return nil, errors.New("connection not associated with swarm")
 	}
-	s.connsLock.RUnlock()
+	s.connLock.RUnlock()
 
-	iconn, ok := conn.(*Conn)
+	iconn, ok := c.(*conn)
 	if !ok {
 		return nil, errors.New("invalid conn")
 	}
 
-	return s.setupStream(iconn)
+	return s.setupStream(ctx, iconn)
 }
 
 // newStream is the internal function that creates a new stream. assumes
 // all validation has happened.
-func (s *Swarm) setupStream(c *conn) (Stream, error) {
+func (s *Swarm) setupStream(ctx context.Context, c *conn) (Stream, error) {
+	if cg := s.ConnectionGater(); cg != nil && !cg.InterceptNewStream(c) {
+		return nil, errors.New("stream rejected by ConnectionGater")
+	}
 
-	// Create a new ss.Stream
-	ssStream, err := c.ssConn.CreateStream(http.Header{}, nil, false)
-	if err != nil {
-		return nil, err
+	if d, ok := streamTimeoutFromContext(ctx); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
 	}
 
-	stream := newStream(c)
-	return stream, nil
+	// Create the ss.Stream in its own goroutine so a context
+	// cancellation/timeout can unblock this call even though
+	// ssConn.CreateStream itself has no context support.
+	type createResult struct {
+		stream *spdystream.Stream
+		err    error
+	}
+	resCh := make(chan createResult, 1)
+	start := time.Now()
+	go func() {
+		ssStream, err := c.ssConn.CreateStream(http.Header{}, nil, false)
+		resCh <- createResult{ssStream, err}
+	}()
+
+	select {
+	case res := <-resCh:
+		if res.err != nil {
+			return nil, res.err
+		}
+		c.observeStreamLatency(time.Since(start))
+		stream := newStream(c, res.stream)
+
+		s.streamLock.Lock()
+		s.streams[stream] = struct{}{}
+		s.streamLock.Unlock()
+
+		c.stat.addStream()
+		if r := s.BandwidthReporter(); r != nil {
+			r.LogOpenedStream(stream.Protocol())
+		}
+		s.notifyOpenedStream(stream)
+		return stream, nil
+	case <-ctx.Done():
+		// ssConn.CreateStream may still succeed after we give up on
+		// it; drain resCh in the background and close a late-arriving
+		// stream instead of leaking it on both sides of the conn.
+		go func() {
+			if res := <-resCh; res.err == nil {
+				res.stream.Close()
+			}
+		}()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrStreamTimeout
+		}
+		return nil, ErrStreamCanceled
+	}
+}
+
+// removeStream removes str from the swarm's active stream set and
+// notifies registered notifiees that it has closed. Callers are
+// responsible for closing the underlying SPDY stream.
+func (s *Swarm) removeStream(str Stream) {
+	s.streamLock.Lock()
+	delete(s.streams, str)
+	s.streamLock.Unlock()
+	if ic, ok := str.Conn().(*conn); ok && ic.stat != nil {
+		ic.stat.removeStream()
+	}
+	s.notifyClosedStream(str)
 }
@@ -0,0 +1,81 @@
+package peerstream
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestTwoSwarmRoundTrip stands up two real Swarms over a TCP loopback
+// listener - one accepting via AddListener, the other dialing and
+// AddConn'ing the resulting net.Conn - opens a Stream from the dialer,
+// and exchanges bytes in both directions. This is the core two-peer
+// use case: it would have caught addConn hardcoding the SPDY "server"
+// flag, since both sides picking the same stream-ID parity deadlocks
+// CreateStream instead of merely misbehaving.
+func TestTwoSwarmRoundTrip(t *testing.T) {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+
+	serverSwarm := NewSwarm()
+
+	received := make(chan string, 1)
+	serverSwarm.SetStreamHandler(func(str Stream) {
+		buf := make([]byte, len("ping"))
+		if _, err := io.ReadFull(str, buf); err != nil {
+			t.Errorf("server read: %v", err)
+			return
+		}
+		if _, err := str.Write([]byte("pong")); err != nil {
+			t.Errorf("server write: %v", err)
+			return
+		}
+		received <- string(buf)
+	})
+
+	serverListener, err := serverSwarm.AddListenerWithRateLimit(nl, RateLimit{}, nil)
+	if err != nil {
+		t.Fatalf("AddListenerWithRateLimit: %v", err)
+	}
+	defer serverListener.Close()
+
+	clientSwarm := NewSwarm()
+
+	netConn, err := net.Dial("tcp", nl.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	if _, err := clientSwarm.AddConn(netConn); err != nil {
+		t.Fatalf("AddConn: %v", err)
+	}
+
+	str, err := clientSwarm.NewStream()
+	if err != nil {
+		t.Fatalf("NewStream: %v", err)
+	}
+	defer str.Close()
+
+	if _, err := str.Write([]byte("ping")); err != nil {
+		t.Fatalf("client write: %v", err)
+	}
+
+	buf := make([]byte, len("pong"))
+	if _, err := io.ReadFull(str, buf); err != nil {
+		t.Fatalf("client read: %v", err)
+	}
+	if got := string(buf); got != "pong" {
+		t.Fatalf("client read = %q, want %q", got, "pong")
+	}
+
+	select {
+	case got := <-received:
+		if got != "ping" {
+			t.Fatalf("server received = %q, want %q", got, "ping")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for server to receive the stream")
+	}
+}